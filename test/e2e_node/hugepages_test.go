@@ -18,7 +18,10 @@ package e2e_node
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +29,7 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
 
 	kubeletconfig "k8s.io/kubernetes/pkg/kubelet/apis/config"
@@ -37,40 +41,74 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-// makeHugePagePod returns a pod that requests the the given amount of huge page memory, and execute the given command
-func makeHugePagePod(baseName string, command string, totalHugePageMemory resource.Quantity, hugePageSize resource.Quantity) *apiv1.Pod {
+// hugepagesDir is the sysfs directory that exposes every hugepage size the
+// running kernel supports, mirroring runc's HugePageSizes().
+const hugepagesDir = "/sys/kernel/mm/hugepages"
+
+// numaNodeDir is the sysfs directory that exposes per-NUMA-node hugepage
+// pools and statistics.
+const numaNodeDir = "/sys/devices/system/node"
+
+// makeHugePagePod returns a pod that requests the given amount(s) of huge
+// page memory, keyed by page size, and executes the given command in
+// containerCount containers (at least 1) that all share the hugetlbfs
+// mount. Each container can read its own index from the CONTAINER_INDEX
+// environment variable so that a single command can branch per container.
+// volumeSizeLimit, if non-nil, is set as the hugetlb EmptyDir's SizeLimit;
+// a nil volumeSizeLimit leaves the volume unbounded, as before.
+func makeHugePagePod(baseName string, command string, hugePageLimits map[string]resource.Quantity, volumeSizeLimit *resource.Quantity, containerCount int) *apiv1.Pod {
 	e2elog.Logf("Pod to run command: %v", command)
+	if containerCount <= 0 {
+		containerCount = 1
+	}
+	baseLimits := apiv1.ResourceList{
+		apiv1.ResourceName("cpu"):    resource.MustParse("10m"),
+		apiv1.ResourceName("memory"): resource.MustParse("100Mi"),
+	}
+	containers := make([]apiv1.Container, 0, containerCount)
+	for i := 0; i < containerCount; i++ {
+		limits := baseLimits.DeepCopy()
+		// Only the first container actually mmaps hugepages; the rest only
+		// need the shared volume mount, so they don't request any.
+		if i == 0 {
+			for sizeName, qty := range hugePageLimits {
+				limits[apiv1.ResourceName("hugepages-"+sizeName)] = qty
+			}
+		}
+		containers = append(containers, apiv1.Container{
+			Image:   imageutils.GetE2EImage(imageutils.HugePageTester),
+			Name:    "container" + string(uuid.NewUUID()),
+			Command: []string{"sh", "-c", command},
+			Env: []apiv1.EnvVar{
+				{Name: "CONTAINER_INDEX", Value: strconv.Itoa(i)},
+			},
+			Resources: apiv1.ResourceRequirements{
+				Limits: limits,
+			},
+			VolumeMounts: []apiv1.VolumeMount{
+				{
+					Name:      "hugetlb",
+					MountPath: "/hugetlb",
+				},
+			},
+		})
+	}
+	hugeTLBVolume := apiv1.EmptyDirVolumeSource{Medium: "HugePages"}
+	if volumeSizeLimit != nil {
+		hugeTLBVolume.SizeLimit = volumeSizeLimit
+	}
 	pod := &apiv1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "pod" + string(uuid.NewUUID()),
 		},
 		Spec: apiv1.PodSpec{
 			RestartPolicy: apiv1.RestartPolicyNever,
-			Containers: []apiv1.Container{
-				{
-					Image:   imageutils.GetE2EImage(imageutils.HugePageTester),
-					Name:    "container" + string(uuid.NewUUID()),
-					Command: []string{"sh", "-c", command},
-					Resources: apiv1.ResourceRequirements{
-						Limits: apiv1.ResourceList{
-							apiv1.ResourceName("cpu"):                                resource.MustParse("10m"),
-							apiv1.ResourceName("memory"):                             resource.MustParse("100Mi"),
-							apiv1.ResourceName("hugepages-" + hugePageSize.String()): totalHugePageMemory,
-						},
-					},
-					VolumeMounts: []apiv1.VolumeMount{
-						{
-							Name:      "hugetlb",
-							MountPath: "/hugetlb",
-						},
-					},
-				},
-			},
+			Containers:    containers,
 			Volumes: []apiv1.Volume{
 				{
 					Name: "hugetlb",
 					VolumeSource: apiv1.VolumeSource{
-						EmptyDir: &apiv1.EmptyDirVolumeSource{Medium: "HugePages"},
+						EmptyDir: &hugeTLBVolume,
 					},
 				},
 			},
@@ -101,13 +139,22 @@ func enableHugePagesInKubelet(f *framework.Framework) *kubeletconfig.KubeletConf
 	return oldCfg
 }
 
-// configureHugePages attempts to allocate _pageCount_ hugepages of the default hugepage size for testing purposes
-func configureHugePages(pageCount int64) error {
-	err := exec.Command("/bin/sh", "-c", fmt.Sprintf("echo %d > /proc/sys/vm/nr_hugepages", pageCount)).Run()
+// nrHugePagesFile returns the sysfs file used to configure the number of
+// pre-allocated hugepages of the given size.
+func nrHugePagesFile(hugePageSize resource.Quantity) string {
+	sizeKB := hugePageSize.Value() / 1024
+	return filepath.Join(hugepagesDir, fmt.Sprintf("hugepages-%dkB", sizeKB), "nr_hugepages")
+}
+
+// configureHugePages attempts to allocate _pageCount_ hugepages of the given
+// hugepage size for testing purposes
+func configureHugePages(hugePageSize resource.Quantity, pageCount int64) error {
+	nrHugePages := nrHugePagesFile(hugePageSize)
+	err := exec.Command("/bin/sh", "-c", fmt.Sprintf("echo %d > %s", pageCount, nrHugePages)).Run()
 	if err != nil {
 		return err
 	}
-	outData, err := exec.Command("/bin/sh", "-c", "cat /proc/meminfo | grep 'HugePages_Total' | awk '{print $2}'").Output()
+	outData, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("cat %s", nrHugePages)).Output()
 	if err != nil {
 		return err
 	}
@@ -115,42 +162,191 @@ func configureHugePages(pageCount int64) error {
 	if err != nil {
 		return err
 	}
-	e2elog.Logf("HugePages_Total is set to %v", numHugePages)
+	e2elog.Logf("HugePages_Total for size %v is set to %v", hugePageSize.String(), numHugePages)
 	if int64(numHugePages) == pageCount {
 		return nil
 	}
-	return fmt.Errorf("expected hugepages %v, but found %v", pageCount, numHugePages)
+	return fmt.Errorf("expected %v hugepages of size %v, but found %v", pageCount, hugePageSize.String(), numHugePages)
 }
 
-// releaseHugePages releases all pre-allocated hugepages
-func releaseHugePages() error {
-	return exec.Command("/bin/sh", "-c", "echo 0 > /proc/sys/vm/nr_hugepages").Run()
+// releaseHugePages releases all pre-allocated hugepages of the given size
+func releaseHugePages(hugePageSize resource.Quantity) error {
+	return exec.Command("/bin/sh", "-c", fmt.Sprintf("echo 0 > %s", nrHugePagesFile(hugePageSize))).Run()
 }
 
-// getDefaultHugePageSize returns the default huge page size, and a boolean if huge pages are supported
+// getDefaultHugePageSize returns the default huge page size, and a boolean if huge pages are supported.
+// It never fails the test; a missing /proc/meminfo field (e.g. on a kernel
+// built without hugetlb) is reported as "not supported" rather than a
+// suite-crashing error, so callers can skip cleanly instead.
 func getDefaultHugePageSize() (resource.Quantity, bool) {
 	outData, err := exec.Command("/bin/sh", "-c", "cat /proc/meminfo | grep 'Hugepagesize:' | awk '{print $2}'").Output()
-	framework.ExpectNoError(err)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
 	pageSize, err := strconv.Atoi(strings.TrimSpace(string(outData)))
-	framework.ExpectNoError(err)
-	if pageSize == 0 {
+	if err != nil || pageSize == 0 {
 		return resource.Quantity{}, false
 	}
 	return *resource.NewQuantity(int64(pageSize*1024), resource.BinarySI), true
 }
 
-func getTestValues() (hugePageSize resource.Quantity, totalMemory resource.Quantity, pageCount int64) {
-	hugePageSize, _ = getDefaultHugePageSize()
-	// If huge page size is  equal to bigger than 1GB, only use two pages
+// getAllHugePageSizes enumerates every hugepage size the running kernel
+// supports by listing the hugepages-*kB directories under hugepagesDir,
+// mirroring runc's HugePageSizes().
+func getAllHugePageSizes() []resource.Quantity {
+	matches, err := filepath.Glob(filepath.Join(hugepagesDir, "hugepages-*kB"))
+	framework.ExpectNoError(err)
+	sizes := make([]resource.Quantity, 0, len(matches))
+	for _, match := range matches {
+		name := filepath.Base(match)
+		sizeKB, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "hugepages-"), "kB"))
+		framework.ExpectNoError(err)
+		sizes = append(sizes, *resource.NewQuantity(int64(sizeKB)*1024, resource.BinarySI))
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i].Value() < sizes[j].Value()
+	})
+	return sizes
+}
+
+// pageCountForSize returns how many hugepages of the given size to
+// pre-allocate for testing purposes.
+func pageCountForSize(hugePageSize resource.Quantity) int64 {
+	// If huge page size is equal to or bigger than 1GB, only use two pages
 	if hugePageSize.Value() >= (1 << 30) {
-		pageCount = 2
-	} else {
-		pageCount = 20
+		return 2
 	}
+	return 20
+}
+
+func getTestValues() (hugePageSize resource.Quantity, totalMemory resource.Quantity, pageCount int64) {
+	hugePageSize, _ = getDefaultHugePageSize()
+	pageCount = pageCountForSize(hugePageSize)
 	totalMemory = *resource.NewQuantity(hugePageSize.Value()*pageCount, resource.BinarySI)
 	return
 }
 
+// HugePageSizeStats holds the four hugepage counters the kernel exposes for
+// a given hugepage size, as read from /sys/kernel/mm/hugepages/hugepages-<size>kB.
+type HugePageSizeStats struct {
+	Total, Free, Surplus, Reserved int64
+}
+
+// HugePageInventory is a point-in-time snapshot of every hugepage size the
+// node supports, collected before and after each test from /proc/meminfo and
+// /sys/kernel/mm/hugepages so that a test can log exactly which counter
+// drifted instead of hard-failing when hugetlb is unsupported or partially
+// configured.
+type HugePageInventory struct {
+	DefaultSize    resource.Quantity
+	SupportedSizes []resource.Quantity
+	Stats          map[string]HugePageSizeStats
+}
+
+// supports reports whether the inventory contains the given hugepage size.
+func (inv HugePageInventory) supports(hugePageSize resource.Quantity) bool {
+	_, ok := inv.Stats[hugePageSize.String()]
+	return ok
+}
+
+// readHugePageSizeFile reads and parses one of the global hugepage
+// statistic files (nr_hugepages, free_hugepages, surplus_hugepages or
+// resv_hugepages) for the given size.
+func readHugePageSizeFile(hugePageSize resource.Quantity, name string) int64 {
+	sizeKB := hugePageSize.Value() / 1024
+	path := filepath.Join(hugepagesDir, fmt.Sprintf("hugepages-%dkB", sizeKB), name)
+	outData, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("cat %s", path)).Output()
+	framework.ExpectNoError(err)
+	value, err := strconv.ParseInt(strings.TrimSpace(string(outData)), 10, 64)
+	framework.ExpectNoError(err)
+	return value
+}
+
+// collectHugePageInventory parses /proc/meminfo and every
+// /sys/kernel/mm/hugepages/hugepages-*kB directory into a HugePageInventory.
+func collectHugePageInventory() HugePageInventory {
+	defaultSize, _ := getDefaultHugePageSize()
+	sizes := getAllHugePageSizes()
+	stats := make(map[string]HugePageSizeStats, len(sizes))
+	for _, size := range sizes {
+		stats[size.String()] = HugePageSizeStats{
+			Total:    readHugePageSizeFile(size, "nr_hugepages"),
+			Free:     readHugePageSizeFile(size, "free_hugepages"),
+			Surplus:  readHugePageSizeFile(size, "surplus_hugepages"),
+			Reserved: readHugePageSizeFile(size, "resv_hugepages"),
+		}
+	}
+	return HugePageInventory{DefaultSize: defaultSize, SupportedSizes: sizes, Stats: stats}
+}
+
+// logHugePageInventoryDiff logs, for every hugepage size known to either
+// inventory, which of the four counters changed between before and after.
+// This makes flake investigation show exactly which counter drifted instead
+// of only a pass/fail result.
+func logHugePageInventoryDiff(before, after HugePageInventory) {
+	for sizeName, afterStats := range after.Stats {
+		beforeStats := before.Stats[sizeName]
+		if beforeStats == afterStats {
+			e2elog.Logf("hugepages-%s: no change (total=%d free=%d surplus=%d resv=%d)",
+				sizeName, afterStats.Total, afterStats.Free, afterStats.Surplus, afterStats.Reserved)
+			continue
+		}
+		e2elog.Logf("hugepages-%s: total %d->%d, free %d->%d, surplus %d->%d, resv %d->%d",
+			sizeName, beforeStats.Total, afterStats.Total, beforeStats.Free, afterStats.Free,
+			beforeStats.Surplus, afterStats.Surplus, beforeStats.Reserved, afterStats.Reserved)
+	}
+	for sizeName, beforeStats := range before.Stats {
+		if _, ok := after.Stats[sizeName]; !ok {
+			e2elog.Logf("hugepages-%s: no longer reported (was total=%d free=%d surplus=%d resv=%d)",
+				sizeName, beforeStats.Total, beforeStats.Free, beforeStats.Surplus, beforeStats.Reserved)
+		}
+	}
+}
+
+// numaNodeCount returns the number of NUMA nodes present on the host.
+func numaNodeCount() int {
+	matches, err := filepath.Glob(filepath.Join(numaNodeDir, "node[0-9]*"))
+	framework.ExpectNoError(err)
+	return len(matches)
+}
+
+// numaHugePagesDir returns the sysfs directory that holds the hugepage pool
+// and statistic files for the given NUMA node and hugepage size.
+func numaHugePagesDir(node int, hugePageSize resource.Quantity) string {
+	sizeKB := hugePageSize.Value() / 1024
+	return filepath.Join(numaNodeDir, fmt.Sprintf("node%d", node), "hugepages", fmt.Sprintf("hugepages-%dkB", sizeKB))
+}
+
+// readNumaHugePageFile reads and parses one of the per-node hugepage
+// statistic files (nr_hugepages, free_hugepages, surplus_hugepages or
+// resv_hugepages).
+func readNumaHugePageFile(node int, hugePageSize resource.Quantity, name string) int64 {
+	path := filepath.Join(numaHugePagesDir(node, hugePageSize), name)
+	outData, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("cat %s", path)).Output()
+	framework.ExpectNoError(err)
+	value, err := strconv.ParseInt(strings.TrimSpace(string(outData)), 10, 64)
+	framework.ExpectNoError(err)
+	return value
+}
+
+// configureNumaHugePages reserves count hugepages of the given size on the
+// given NUMA node, by writing to its per-node nr_hugepages file.
+func configureNumaHugePages(node int, hugePageSize resource.Quantity, count int64) error {
+	nrHugePages := filepath.Join(numaHugePagesDir(node, hugePageSize), "nr_hugepages")
+	return exec.Command("/bin/sh", "-c", fmt.Sprintf("echo %d > %s", count, nrHugePages)).Run()
+}
+
+// readNumaHugePageStats returns the total, free, surplus and reserved
+// hugepage counters for the given NUMA node and hugepage size, as exposed by
+// /sys/devices/system/node/node<N>/hugepages/hugepages-<size>kB.
+func readNumaHugePageStats(node int, hugePageSize resource.Quantity) (total, free, surplus, reserved int64) {
+	total = readNumaHugePageFile(node, hugePageSize, "nr_hugepages")
+	free = readNumaHugePageFile(node, hugePageSize, "free_hugepages")
+	surplus = readNumaHugePageFile(node, hugePageSize, "surplus_hugepages")
+	reserved = readNumaHugePageFile(node, hugePageSize, "resv_hugepages")
+	return
+}
+
 // pollResourceAsString polls for a specified resource and capacity from node
 func pollResourceAsString(f *framework.Framework, resourceName string) string {
 	node, err := f.ClientSet.CoreV1().Nodes().Get(framework.TestContext.NodeName, metav1.GetOptions{})
@@ -169,6 +365,86 @@ func amountOfResourceAsString(node *apiv1.Node, resourceName string) string {
 	return val.String()
 }
 
+// hugetlbCgroupRoot is the root of the hugetlb cgroup hierarchy kubelet
+// manages for pods on this node.
+const hugetlbCgroupRoot = "/sys/fs/cgroup/hugetlb/kubepods"
+
+// unboundedHugeTLBLimit is the value qos_container_manager_linux.go writes
+// to the Burstable and BestEffort hugetlb cgroups, meaning "no limit".
+const unboundedHugeTLBLimit = int64(1) << 62
+
+// qosCgroupPath returns the hugetlb cgroup directory for the given QoS
+// class. Guaranteed pods have no QoS-level cgroup of their own and inherit
+// the root cgroup.
+func qosCgroupPath(qosClass apiv1.PodQOSClass) string {
+	switch qosClass {
+	case apiv1.PodQOSBurstable:
+		return filepath.Join(hugetlbCgroupRoot, "burstable")
+	case apiv1.PodQOSBestEffort:
+		return filepath.Join(hugetlbCgroupRoot, "besteffort")
+	default:
+		return hugetlbCgroupRoot
+	}
+}
+
+// podCgroupPath returns the hugetlb cgroup directory for the given pod.
+func podCgroupPath(qosClass apiv1.PodQOSClass, podUID types.UID) string {
+	return filepath.Join(qosCgroupPath(qosClass), "pod"+string(podUID))
+}
+
+// containerCgroupPath returns the hugetlb cgroup directory of the single
+// container running under the given pod cgroup. The pod cgroup directory
+// also contains the pod's own cgroup control files (cgroup.procs,
+// hugetlb.<size>.limit_in_bytes, ...), so entries are filtered down to
+// subdirectories before picking the one container cgroup.
+func containerCgroupPath(podCgroup string) string {
+	entries, err := filepath.Glob(filepath.Join(podCgroup, "*"))
+	framework.ExpectNoError(err)
+	var dirs []string
+	for _, entry := range entries {
+		info, err := os.Stat(entry)
+		framework.ExpectNoError(err)
+		if info.IsDir() {
+			dirs = append(dirs, entry)
+		}
+	}
+	Expect(dirs).To(HaveLen(1), "expected exactly one container cgroup directory under %s", podCgroup)
+	return dirs[0]
+}
+
+// hugeTLBCgroupSizeName formats a hugepage size the way the kernel names the
+// corresponding hugetlb cgroup control files (e.g. "2MB", "1GB"), which is
+// not the same as Kubernetes' binary-SI resource.Quantity string (e.g.
+// "2Mi", "1Gi").
+func hugeTLBCgroupSizeName(hugePageSize resource.Quantity) string {
+	value := hugePageSize.Value()
+	units := []struct {
+		size   int64
+		suffix string
+	}{
+		{1 << 30, "GB"},
+		{1 << 20, "MB"},
+		{1 << 10, "KB"},
+	}
+	for _, unit := range units {
+		if value%unit.size == 0 {
+			return fmt.Sprintf("%d%s", value/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", value)
+}
+
+// readCgroupHugeTLBLimit reads hugetlb.<size>.limit_in_bytes from the given
+// cgroup directory.
+func readCgroupHugeTLBLimit(cgroupPath string, hugePageSize resource.Quantity) int64 {
+	file := filepath.Join(cgroupPath, fmt.Sprintf("hugetlb.%s.limit_in_bytes", hugeTLBCgroupSizeName(hugePageSize)))
+	outData, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("cat %s", file)).Output()
+	framework.ExpectNoError(err)
+	limitInBytes, err := strconv.ParseInt(strings.TrimSpace(string(outData)), 10, 64)
+	framework.ExpectNoError(err)
+	return limitInBytes
+}
+
 func runHugePagesTests(f *framework.Framework) {
 	fileName := "/hugetlb/file"
 	It("should assign hugepages as expected based on the Pod spec", func() {
@@ -176,7 +452,7 @@ func runHugePagesTests(f *framework.Framework) {
 		By("running a pod that requests hugepages and allocates the memory")
 		command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value(), hugePageSize.Value(), fileName)
 
-		verifyPod := makeHugePagePod("hugepage-pod", command, totalHugePageMemory, hugePageSize)
+		verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, nil, 1)
 		f.PodClient().Create(verifyPod)
 		err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
 		By("checking that pod execution succeeded")
@@ -188,7 +464,7 @@ func runHugePagesTests(f *framework.Framework) {
 		By("running a pod that requests hugepages and allocates twice the amount of the requested memory")
 		command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value()*2, hugePageSize.Value(), fileName)
 
-		verifyPod := makeHugePagePod("hugepage-pod", command, totalHugePageMemory, hugePageSize)
+		verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, nil, 1)
 		f.PodClient().Create(verifyPod)
 		err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
 		By("checking that pod execution failed")
@@ -199,13 +475,213 @@ func runHugePagesTests(f *framework.Framework) {
 		By("running a pod that requests hugepages and allocates using a page size euqal to twice the requested size")
 		command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value(), hugePageSize.Value()*2, fileName)
 
-		verifyPod := makeHugePagePod("hugepage-pod", command, totalHugePageMemory, hugePageSize)
+		verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, nil, 1)
 		f.PodClient().Create(verifyPod)
 		err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
 		By("checking that pod execution failed")
 		Expect(err).To(HaveOccurred())
 
 	})
+
+	Context("with multiple hugepage sizes", func() {
+		var hugePageSizes []resource.Quantity
+
+		BeforeEach(func() {
+			hugePageSizes = getAllHugePageSizes()
+			if len(hugePageSizes) < 2 {
+				framework.Skipf("skipping test because the node only supports a single hugepage size")
+			}
+		})
+
+		It("should not allow a pod requesting one size to mmap another size", func() {
+			requestedSize := hugePageSizes[0]
+			otherSize := hugePageSizes[1]
+			totalHugePageMemory := *resource.NewQuantity(requestedSize.Value()*pageCountForSize(requestedSize), resource.BinarySI)
+			By(fmt.Sprintf("running a pod that requests %s hugepages and allocates %s hugepages", requestedSize.String(), otherSize.String()))
+			command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value(), otherSize.Value(), fileName)
+
+			verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{requestedSize.String(): totalHugePageMemory}, nil, 1)
+			f.PodClient().Create(verifyPod)
+			err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
+			By("checking that pod execution failed")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should assign the correct cgroup limit for a pod requesting multiple hugepage sizes", func() {
+			By("running a pod that requests every supported hugepage size in a single container")
+			limits := make(map[string]resource.Quantity, len(hugePageSizes))
+			var commands []string
+			for i, size := range hugePageSizes {
+				totalHugePageMemory := *resource.NewQuantity(size.Value()*pageCountForSize(size), resource.BinarySI)
+				limits[size.String()] = totalHugePageMemory
+				commands = append(commands, fmt.Sprintf(`./hugetlb-tester %d %d %s-%d`, totalHugePageMemory.Value(), size.Value(), fileName, i))
+			}
+			command := strings.Join(commands, " && ")
+
+			verifyPod := makeHugePagePod("hugepage-pod", command, limits, nil, 1)
+			f.PodClient().Create(verifyPod)
+			err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
+			By("checking that pod execution succeeded")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking that the pod's hugetlb cgroup limit matches each requested size")
+			podUID := f.PodClient().Get(verifyPod.Name).UID
+			podCgroup := podCgroupPath(apiv1.PodQOSGuaranteed, podUID)
+			for _, size := range hugePageSizes {
+				sizeName := size.String()
+				Expect(readCgroupHugeTLBLimit(podCgroup, size)).To(Equal(limits[sizeName].Value()))
+			}
+		})
+
+		It("should advertise capacity for every hugepage size discovered on the node", func() {
+			for _, size := range hugePageSizes {
+				By(fmt.Sprintf("checking that the node advertises a capacity for hugepages-%s", size.String()))
+				Eventually(func() string {
+					return pollResourceAsString(f, "hugepages-"+size.String())
+				}, 30*time.Second, framework.Poll).ShouldNot(Equal(""))
+			}
+		})
+	})
+
+	Context("with pods of different QoS classes", func() {
+		It("should set the Burstable QoS cgroup hugetlb limit to unbounded and the pod/container limits to the request", func() {
+			hugePageSize, totalHugePageMemory, _ := getTestValues()
+			By("running a Burstable pod that requests hugepages")
+			command := "sleep 3600"
+			pod := makeHugePagePod("hugepage-burstable-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, nil, 1)
+			pod.Spec.Containers[0].Resources.Requests = apiv1.ResourceList{
+				apiv1.ResourceName("cpu"):    resource.MustParse("5m"),
+				apiv1.ResourceName("memory"): resource.MustParse("50Mi"),
+			}
+			pod = f.PodClient().CreateSync(pod)
+			Expect(pod.Status.QOSClass).To(Equal(apiv1.PodQOSBurstable))
+
+			podCgroup := podCgroupPath(apiv1.PodQOSBurstable, pod.UID)
+			By("checking that the Burstable QoS cgroup hugetlb limit is unbounded")
+			Expect(readCgroupHugeTLBLimit(qosCgroupPath(apiv1.PodQOSBurstable), hugePageSize)).To(Equal(unboundedHugeTLBLimit))
+			By("checking that the pod-level hugetlb limit equals the sum of container requests")
+			Expect(readCgroupHugeTLBLimit(podCgroup, hugePageSize)).To(Equal(totalHugePageMemory.Value()))
+			By("checking that the container-level hugetlb limit equals its own request")
+			Expect(readCgroupHugeTLBLimit(containerCgroupPath(podCgroup), hugePageSize)).To(Equal(totalHugePageMemory.Value()))
+
+			f.PodClient().DeleteSync(pod.Name, &metav1.DeleteOptions{}, framework.DefaultPodDeletionTimeout)
+		})
+
+		It("should set the Guaranteed pod/container hugetlb limits to the request", func() {
+			hugePageSize, totalHugePageMemory, _ := getTestValues()
+			By("running a Guaranteed pod that requests hugepages")
+			command := "sleep 3600"
+			pod := makeHugePagePod("hugepage-guaranteed-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, nil, 1)
+			pod = f.PodClient().CreateSync(pod)
+			Expect(pod.Status.QOSClass).To(Equal(apiv1.PodQOSGuaranteed))
+
+			podCgroup := podCgroupPath(apiv1.PodQOSGuaranteed, pod.UID)
+			By("checking that the pod-level hugetlb limit equals the sum of container requests")
+			Expect(readCgroupHugeTLBLimit(podCgroup, hugePageSize)).To(Equal(totalHugePageMemory.Value()))
+			By("checking that the container-level hugetlb limit equals its own request")
+			Expect(readCgroupHugeTLBLimit(containerCgroupPath(podCgroup), hugePageSize)).To(Equal(totalHugePageMemory.Value()))
+
+			f.PodClient().DeleteSync(pod.Name, &metav1.DeleteOptions{}, framework.DefaultPodDeletionTimeout)
+		})
+
+		It("should set the BestEffort QoS cgroup hugetlb limit to unbounded and the pod/container limits to the request", func() {
+			hugePageSize, totalHugePageMemory, _ := getTestValues()
+			By("running a BestEffort pod that requests hugepages")
+			pod := &apiv1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "hugepage-besteffort-pod" + string(uuid.NewUUID()),
+				},
+				Spec: apiv1.PodSpec{
+					RestartPolicy: apiv1.RestartPolicyNever,
+					Containers: []apiv1.Container{
+						{
+							Image:   imageutils.GetE2EImage(imageutils.HugePageTester),
+							Name:    "container" + string(uuid.NewUUID()),
+							Command: []string{"sh", "-c", "sleep 3600"},
+							Resources: apiv1.ResourceRequirements{
+								Limits: apiv1.ResourceList{
+									apiv1.ResourceName("hugepages-" + hugePageSize.String()): totalHugePageMemory,
+								},
+							},
+						},
+					},
+				},
+			}
+			pod = f.PodClient().CreateSync(pod)
+			Expect(pod.Status.QOSClass).To(Equal(apiv1.PodQOSBestEffort))
+
+			podCgroup := podCgroupPath(apiv1.PodQOSBestEffort, pod.UID)
+			By("checking that the BestEffort QoS cgroup hugetlb limit is unbounded")
+			Expect(readCgroupHugeTLBLimit(qosCgroupPath(apiv1.PodQOSBestEffort), hugePageSize)).To(Equal(unboundedHugeTLBLimit))
+			By("checking that the pod-level hugetlb limit equals the sum of container requests")
+			Expect(readCgroupHugeTLBLimit(podCgroup, hugePageSize)).To(Equal(totalHugePageMemory.Value()))
+			By("checking that the container-level hugetlb limit equals its own request")
+			Expect(readCgroupHugeTLBLimit(containerCgroupPath(podCgroup), hugePageSize)).To(Equal(totalHugePageMemory.Value()))
+
+			f.PodClient().DeleteSync(pod.Name, &metav1.DeleteOptions{}, framework.DefaultPodDeletionTimeout)
+		})
+	})
+
+	Context("with the hugetlbfs EmptyDir SizeLimit set", func() {
+		It("should fail when a container writes past a SizeLimit lower than its hugepage request", func() {
+			hugePageSize, totalHugePageMemory, _ := getTestValues()
+			volumeSizeLimit := resource.NewQuantity(totalHugePageMemory.Value()/2, resource.BinarySI)
+			By("running a pod whose hugetlb volume SizeLimit is below its hugepage request")
+			command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value(), hugePageSize.Value(), fileName)
+
+			verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, volumeSizeLimit, 1)
+			f.PodClient().Create(verifyPod)
+			err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
+			By("checking that the pod was rejected or evicted for writing past the volume SizeLimit")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should succeed up to the container hugepage limit when the SizeLimit is above the request", func() {
+			hugePageSize, totalHugePageMemory, _ := getTestValues()
+			volumeSizeLimit := resource.NewQuantity(totalHugePageMemory.Value()*2, resource.BinarySI)
+			By("running a pod whose hugetlb volume SizeLimit is above its hugepage request")
+			command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value(), hugePageSize.Value(), fileName)
+
+			verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, volumeSizeLimit, 1)
+			f.PodClient().Create(verifyPod)
+			err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
+			By("checking that mmap succeeded up to the container hugepage limit")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should let two containers in the same pod share mmap'd hugetlbfs memory", func() {
+			hugePageSize, totalHugePageMemory, _ := getTestValues()
+			volumeSizeLimit := resource.NewQuantity(totalHugePageMemory.Value()*2, resource.BinarySI)
+			By("running a pod with two containers that share the hugetlbfs mount, one writing and one reading")
+			// The second container only has a POSIX shell available, so it
+			// verifies cross-container visibility by checking that the file
+			// the first container mmap'd and wrote is visible with its full
+			// size on the shared hugetlbfs mount, rather than assuming
+			// hugetlb-tester supports an unverified read-back flag.
+			command := fmt.Sprintf(
+				`if [ "$CONTAINER_INDEX" = "0" ]; then ./hugetlb-tester %d %d %s; else sleep 5 && test "$(stat -c%%s %s)" = "%d"; fi`,
+				totalHugePageMemory.Value(), hugePageSize.Value(), fileName,
+				fileName, totalHugePageMemory.Value())
+
+			verifyPod := makeHugePagePod("hugepage-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, volumeSizeLimit, 2)
+			f.PodClient().Create(verifyPod)
+			err := framework.WaitForPodSuccessInNamespace(f.ClientSet, verifyPod.Name, f.Namespace.Name)
+			By("checking that the reading container observed the memory written by the other container")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking that the combined hugepage usage stayed within the pod-level cgroup limit")
+			podUID := f.PodClient().Get(verifyPod.Name).UID
+			podCgroup := podCgroupPath(apiv1.PodQOSGuaranteed, podUID)
+			podLimit := readCgroupHugeTLBLimit(podCgroup, hugePageSize)
+			Expect(podLimit).To(Equal(totalHugePageMemory.Value()))
+			usageFile := filepath.Join(podCgroup, fmt.Sprintf("hugetlb.%s.max_usage_in_bytes", hugeTLBCgroupSizeName(hugePageSize)))
+			outData, err := exec.Command("/bin/sh", "-c", fmt.Sprintf("cat %s", usageFile)).Output()
+			framework.ExpectNoError(err)
+			maxUsage, err := strconv.ParseInt(strings.TrimSpace(string(outData)), 10, 64)
+			framework.ExpectNoError(err)
+			Expect(maxUsage).To(BeNumerically("<=", podLimit))
+		})
+	})
 }
 
 // Serial because the test updates kubelet configuration.
@@ -214,45 +690,56 @@ var _ = SIGDescribe("HugePages [Serial] [Feature:HugePages][NodeFeature:HugePage
 
 	Context("With config updated with hugepages feature enabled", func() {
 		var oldCfg *kubeletconfig.KubeletConfiguration
+		var preInventory HugePageInventory
 
 		BeforeEach(func() {
-			By("verifying hugepages are supported")
-
-			hugePageSize, supported := getDefaultHugePageSize()
-			_, totalHugePageMemory, pageCount := getTestValues()
-			if !supported {
+			By("checking the hugepage inventory before reserving any pages")
+			inventory := collectHugePageInventory()
+			if !inventory.supports(inventory.DefaultSize) {
 				framework.Skipf("skipping test because hugepages are not supported")
 				return
 			}
-			By("configuring the host to reserve a number of pre-allocated hugepages")
-			Eventually(func() error {
-				err := configureHugePages(pageCount)
-				if err != nil {
-					return err
-				}
-				return nil
-			}, 30*time.Second, framework.Poll).Should(BeNil())
+			hugePageSizes := inventory.SupportedSizes
+			By("configuring the host to reserve a small pool of pre-allocated hugepages for every supported size")
+			for _, size := range hugePageSizes {
+				size := size
+				Eventually(func() error {
+					return configureHugePages(size, pageCountForSize(size))
+				}, 30*time.Second, framework.Poll).Should(BeNil())
+			}
 			By("enabling hugepages in kubelet")
 			oldCfg = enableHugePagesInKubelet(f)
 			By("restarting kubelet to pick up pre-allocated hugepages")
 			restartKubelet()
 			By("by waiting for hugepages resource to become available on the local node")
-			Eventually(func() string {
-				return pollResourceAsString(f, "hugepages-"+hugePageSize.String())
-			}, 30*time.Second, framework.Poll).Should(Equal(totalHugePageMemory.String()))
+			for _, size := range hugePageSizes {
+				pageCount := pageCountForSize(size)
+				totalHugePageMemory := *resource.NewQuantity(size.Value()*pageCount, resource.BinarySI)
+				Eventually(func() string {
+					return pollResourceAsString(f, "hugepages-"+size.String())
+				}, 30*time.Second, framework.Poll).Should(Equal(totalHugePageMemory.String()))
+			}
+		})
+
+		JustBeforeEach(func() {
+			preInventory = collectHugePageInventory()
 		})
 
 		runHugePagesTests(f)
 
+		AfterEach(func() {
+			By("logging the hugepage inventory diff for this test")
+			logHugePageInventoryDiff(preInventory, collectHugePageInventory())
+		})
+
 		AfterEach(func() {
 			By("Releasing hugepages")
-			Eventually(func() error {
-				err := releaseHugePages()
-				if err != nil {
-					return err
-				}
-				return nil
-			}, 30*time.Second, framework.Poll).Should(BeNil())
+			for _, size := range getAllHugePageSizes() {
+				size := size
+				Eventually(func() error {
+					return releaseHugePages(size)
+				}, 30*time.Second, framework.Poll).Should(BeNil())
+			}
 			if oldCfg != nil {
 				By("Restoring old kubelet config")
 				setOldKubeletConfig(f, oldCfg)
@@ -266,4 +753,95 @@ var _ = SIGDescribe("HugePages [Serial] [Feature:HugePages][NodeFeature:HugePage
 			}, 30*time.Second, framework.Poll).Should(Equal("0"))
 		})
 	})
+
+	Context("With the Topology Manager single-numa-node policy enabled", func() {
+		var oldCfg *kubeletconfig.KubeletConfiguration
+		var hugePageSize resource.Quantity
+		var pinnedNode int
+		const pageCount = 8
+
+		BeforeEach(func() {
+			By("verifying hugepages are supported and multiple NUMA nodes are present")
+			var supported bool
+			hugePageSize, supported = getDefaultHugePageSize()
+			if !supported {
+				framework.Skipf("skipping test because hugepages are not supported")
+				return
+			}
+			if numaNodeCount() < 2 {
+				framework.Skipf("skipping test because the node does not have multiple NUMA nodes")
+				return
+			}
+			pinnedNode = 0
+			By(fmt.Sprintf("reserving %d hugepages of size %s on NUMA node %d", pageCount, hugePageSize.String(), pinnedNode))
+			Eventually(func() error {
+				return configureNumaHugePages(pinnedNode, hugePageSize, pageCount)
+			}, 30*time.Second, framework.Poll).Should(BeNil())
+
+			By("enabling the single-numa-node Topology Manager policy in kubelet")
+			oldCfg, _ = getCurrentKubeletConfig()
+			newCfg := oldCfg.DeepCopy()
+			if newCfg.FeatureGates == nil {
+				newCfg.FeatureGates = make(map[string]bool)
+			}
+			newCfg.FeatureGates["HugePages"] = true
+			newCfg.FeatureGates["TopologyManager"] = true
+			newCfg.CPUManagerPolicy = "static"
+			newCfg.TopologyManagerPolicy = "single-numa-node"
+			newCfg.ReservedSystemCPUs = "0"
+			framework.ExpectNoError(setKubeletConfiguration(f, newCfg))
+
+			By("restarting kubelet to pick up the pre-allocated hugepages and the new policy")
+			restartKubelet()
+			totalHugePageMemory := *resource.NewQuantity(hugePageSize.Value()*pageCount, resource.BinarySI)
+			Eventually(func() string {
+				return pollResourceAsString(f, "hugepages-"+hugePageSize.String())
+			}, 30*time.Second, framework.Poll).Should(Equal(totalHugePageMemory.String()))
+		})
+
+		AfterEach(func() {
+			By("releasing the per-node hugepages")
+			Eventually(func() error {
+				return configureNumaHugePages(pinnedNode, hugePageSize, 0)
+			}, 30*time.Second, framework.Poll).Should(BeNil())
+			if oldCfg != nil {
+				By("restoring old kubelet config")
+				setOldKubeletConfig(f, oldCfg)
+			}
+			By("restarting kubelet to release hugepages")
+			restartKubelet()
+		})
+
+		It("should allocate hugepages only from the NUMA node the pod is pinned to", func() {
+			totalHugePageMemory := *resource.NewQuantity(hugePageSize.Value()*pageCount, resource.BinarySI)
+			otherNode := (pinnedNode + 1) % numaNodeCount()
+
+			By("recording the hugepage stats on the pinned and one other NUMA node before running the pod")
+			_, pinnedFreeBefore, _, _ := readNumaHugePageStats(pinnedNode, hugePageSize)
+			_, otherFreeBefore, _, _ := readNumaHugePageStats(otherNode, hugePageSize)
+
+			By("running a Guaranteed pod with a CPU request that forces single-NUMA-node pinning")
+			fileName := "/hugetlb/file"
+			command := fmt.Sprintf(`./hugetlb-tester %d %d %s`, totalHugePageMemory.Value(), hugePageSize.Value(), fileName)
+			pod := makeHugePagePod("hugepage-numa-pod", command, map[string]resource.Quantity{hugePageSize.String(): totalHugePageMemory}, nil, 1)
+			// The CPU Manager's static policy only grants exclusive,
+			// single-NUMA-node cpuset pinning to Guaranteed pods with an
+			// integer CPU request; a millicpu request stays in the shared
+			// pool spanning every NUMA node.
+			pod.Spec.Containers[0].Resources.Limits[apiv1.ResourceCPU] = resource.MustParse("1")
+			pod.Spec.Containers[0].Resources.Requests = pod.Spec.Containers[0].Resources.Limits
+			f.PodClient().Create(pod)
+			err := framework.WaitForPodSuccessInNamespace(f.ClientSet, pod.Name, f.Namespace.Name)
+			By("checking that pod execution succeeded")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("checking that free_hugepages dropped on the pinned NUMA node by exactly the number of pages used")
+			_, pinnedFreeAfter, _, _ := readNumaHugePageStats(pinnedNode, hugePageSize)
+			Expect(pinnedFreeBefore - pinnedFreeAfter).To(Equal(int64(pageCount)))
+
+			By("checking that the other NUMA node was untouched")
+			_, otherFreeAfter, _, _ := readNumaHugePageStats(otherNode, hugePageSize)
+			Expect(otherFreeAfter).To(Equal(otherFreeBefore))
+		})
+	})
 })